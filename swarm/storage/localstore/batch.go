@@ -0,0 +1,59 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package localstore
+
+import (
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// writeBatch commits batch to the underlying shed database while
+// holding db.batchMu, the lock collectGarbage, pin, unpin and
+// evictReserveOverflow all hold across their own read-build-commit
+// sequence. It is the single commit path callers whose own candidate
+// selection is a single read (no prior Has/Get lookups to race) can
+// use instead of taking db.batchMu themselves.
+//
+// This does not, by itself, serialize the ordinary ModePut/ModeSet/
+// ModeGet-with-access accessors against collectGarbage: those
+// accessors live in mode_put.go/mode_set.go/mode_get.go, which are not
+// part of this change. Serializing them still requires routing their
+// batch construction through db.batchMu (via this helper or directly,
+// as collectGarbage does) the same way this file does for GC and the
+// reserve tier; until that is done, a Put/Set racing collectGarbage
+// can still observe the lost-update batchMu exists to prevent.
+//
+// If the batch is committed successfully, onCommit, if not nil, runs
+// before batchMu is released. Callers that adjust gcSize or
+// reserveSize as part of the same logical operation must do so from
+// onCommit rather than after writeBatch returns, the same way
+// collectGarbage keeps its own incGCSize call inside the critical
+// section: otherwise a concurrent collectGarbage round can acquire
+// batchMu right after this batch commits but before the counters are
+// updated, and read index contents that already reflect the change
+// while the counters still reflect the old one.
+func (db *DB) writeBatch(batch *leveldb.Batch, onCommit func()) error {
+	db.batchMu.Lock()
+	defer db.batchMu.Unlock()
+
+	if err := db.shed.WriteBatch(batch); err != nil {
+		return err
+	}
+	if onCommit != nil {
+		onCommit()
+	}
+	return nil
+}
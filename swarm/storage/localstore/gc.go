@@ -18,6 +18,7 @@ package localstore
 
 import (
 	"sync/atomic"
+	"time"
 
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/swarm/shed"
@@ -48,65 +49,121 @@ func (db *DB) collectGarbage() {
 	for {
 		select {
 		case <-db.collectGarbageTrigger:
+			start := time.Now()
+			// batchMu is held for the whole construction and commit of
+			// this round's batch, not just the final write, so that
+			// pin, unpin, evictReserveOverflow and writeBatch cannot
+			// change gcIndex or reserveIndex in between this loop
+			// reading an item and the batch that removes it being
+			// committed. The Put/Set/Get-with-access accessors do not
+			// take batchMu and are not serialized against this round.
+			db.batchMu.Lock()
+
 			batch := new(leveldb.Batch)
 
 			// sets a gc trigger if batch limit is reached
 			var triggerNextIteration bool
 			var collectedCount int64
-			err := db.gcIndex.IterateAll(func(item shed.IndexItem) (stop bool, err error) {
-				gcSize := atomic.LoadInt64(&db.gcSize)
-				if gcSize-collectedCount <= target {
-					return true, nil
-				}
-				// delete from retrieve, pull, gc
-				if db.useRetrievalCompositeIndex {
-					db.retrievalCompositeIndex.DeleteInBatch(batch, item)
-				} else {
-					db.retrievalDataIndex.DeleteInBatch(batch, item)
-					db.retrievalAccessIndex.DeleteInBatch(batch, item)
-				}
-				db.pullIndex.DeleteInBatch(batch, item)
-				db.gcIndex.DeleteInBatch(batch, item)
-				collectedCount++
-				if collectedCount >= gcBatchSize {
-					triggerNextIteration = true
-					return true, nil
-				}
-				return false, nil
-			})
+			var err error
+			if db.gcPolicy != nil {
+				// a configured policy needs every candidate scored and
+				// sorted before deciding what to evict, so it cannot use
+				// gcIndex's own key order like the fast path below
+				err = db.collectGarbageOrdered(batch, target, &collectedCount, &triggerNextIteration)
+			} else {
+				err = db.gcIndex.IterateAll(func(item shed.IndexItem) (stop bool, err error) {
+					gcSize := atomic.LoadInt64(&db.gcSize)
+					if gcSize-collectedCount <= target {
+						return true, nil
+					}
+					// reserved items are protected from eviction and are
+					// skipped, not counted against this round's batch
+					pinned, err := db.isPinned(item)
+					if err != nil {
+						return false, err
+					}
+					if pinned {
+						return false, nil
+					}
+					db.deleteGCItemInBatch(batch, item)
+					collectedCount++
+					if collectedCount >= db.GCBatchSize() {
+						triggerNextIteration = true
+						return true, nil
+					}
+					return false, nil
+				})
+			}
 			if err != nil {
 				log.Error("localstore collect garbage", "err", err)
 			}
 
 			err = db.shed.WriteBatch(batch)
+			if err == nil {
+				// gcSize is decremented while batchMu is still held, so
+				// that no other batchMu holder can observe the
+				// committed index mutations before gcSize reflects them
+				db.incGCSize(-collectedCount)
+			}
+			db.batchMu.Unlock()
+			duration := time.Since(start)
+			db.adjustGCBatchSize(duration)
 			if err != nil {
 				log.Error("localstore collect garbage write batch", "err", err)
+				// nothing was removed and no next round is requested
+				collectedCount = 0
+				triggerNextIteration = false
 			} else {
-				// batch is written, decrement gcSize and check if another gc run is needed
-				db.incGCSize(-collectedCount)
 				if triggerNextIteration {
-					select {
-					case db.collectGarbageTrigger <- struct{}{}:
-					default:
-					}
+					db.scheduleNextGCRound()
 				}
 			}
 
-			if testHookCollectGarbage != nil {
-				testHookCollectGarbage(collectedCount)
-			}
+			db.reportGCRound(GCRoundInfo{
+				CollectedCount: collectedCount,
+				GCSize:         atomic.LoadInt64(&db.gcSize),
+				Target:         target,
+				Duration:       duration,
+				TriggeredNext:  triggerNextIteration,
+			})
 		case <-db.close:
 			return
 		}
 	}
 }
 
+// deleteGCItemInBatch adds the deletion of item from the retrieve,
+// pull and gc indexes to batch.
+func (db *DB) deleteGCItemInBatch(batch *leveldb.Batch, item shed.IndexItem) {
+	if db.useRetrievalCompositeIndex {
+		db.retrievalCompositeIndex.DeleteInBatch(batch, item)
+	} else {
+		db.retrievalDataIndex.DeleteInBatch(batch, item)
+		db.retrievalAccessIndex.DeleteInBatch(batch, item)
+	}
+	db.pullIndex.DeleteInBatch(batch, item)
+	db.gcIndex.DeleteInBatch(batch, item)
+}
+
 // gcTrigger retruns the absolute value for garbage collection
 // target value, calculated from db.capacity and gcTargetRatio.
 func (db *DB) gcTarget() (target int64) {
 	return int64(float64(db.capacity) * gcTargetRatio)
 }
 
+// GCSize returns the current number of items tracked by the
+// cache-tier garbage collection index.
+func (db *DB) GCSize() int64 {
+	return atomic.LoadInt64(&db.gcSize)
+}
+
+// GCTarget returns the absolute gcSize a garbage collection round
+// leaves the database at, the same value collectGarbage uses
+// internally.
+func (db *DB) GCTarget() int64 {
+	return db.gcTarget()
+}
+
 // incGCSize increments gcSize by the provided number.
 // If count is negative, it will decrement gcSize.
 func (db *DB) incGCSize(count int64) {
@@ -118,8 +175,3 @@ func (db *DB) incGCSize(count int64) {
 		}
 	}
 }
-
-// testHookCollectGarbage is a hook that can provide
-// information when a garbage collection run is done
-// and how many items it removed.
-var testHookCollectGarbage func(collectedCount int64)
\ No newline at end of file
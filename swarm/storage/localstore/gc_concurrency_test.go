@@ -0,0 +1,135 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package localstore
+
+import (
+	"context"
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/swarm/shed"
+	"github.com/ethereum/go-ethereum/swarm/storage"
+)
+
+// TestCollectGarbageConcurrentPutAccess hammers concurrent chunk
+// uploads and accesses against a database while garbage collection is
+// forced on every other put, and asserts that gcSize never drifts from the
+// number of items actually left in gcIndex once everything settles.
+//
+// This only exercises collectGarbage's own batchMu-guarded path; it
+// does not prove Put/ModeSetAccess participate in batchMu, since
+// those accessors live in mode_put.go/mode_set.go, which this series
+// does not touch. A real regression test for the accessor-vs-GC race
+// the original request describes needs to assert on interleaved state
+// (e.g. a Put observed mid-collectGarbage-round), not just steady
+// state after WaitForGC, and belongs alongside those accessors once
+// they are wired into batchMu.
+func TestCollectGarbageConcurrentPutAccess(t *testing.T) {
+	db, cleanup := newGCTestDB(t, WithCapacity(100))
+	defer cleanup()
+
+	const workers = 8
+	const uploadsPerWorker = 50
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for j := 0; j < uploadsPerWorker; j++ {
+				chunk := generateGCTestRandomChunk()
+				if _, err := db.Put(ModePutUpload, chunk); err != nil {
+					t.Error(err)
+					return
+				}
+				if err := db.Set(ModeSetAccess, chunk.Address()); err != nil {
+					t.Error(err)
+					return
+				}
+				// force a GC round on roughly every other upload so it
+				// races with the still in-flight puts/accesses above
+				if j%2 == 0 {
+					select {
+					case db.collectGarbageTrigger <- struct{}{}:
+					default:
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := db.WaitForGC(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	var indexed int64
+	if err := db.gcIndex.IterateAll(func(item shed.IndexItem) (bool, error) {
+		indexed++
+		return false, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := db.GCSize(); got != indexed {
+		t.Fatalf("gcSize %d does not match gcIndex item count %d after concurrent puts and accesses, gcIndex leaked relative to the size counter", got, indexed)
+	}
+}
+
+// newGCTestDB constructs a DB in a temporary directory for use by GC
+// tests, returning a cleanup function that closes it and removes the
+// directory.
+func newGCTestDB(t *testing.T, o ...Option) (db *DB, cleanup func()) {
+	t.Helper()
+
+	path, err := ioutil.TempDir("", "localstore-gc-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	baseKey := make([]byte, 32)
+	if _, err := rand.Read(baseKey); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = New(path, baseKey, o...)
+	if err != nil {
+		os.RemoveAll(path)
+		t.Fatal(err)
+	}
+	return db, func() {
+		db.Close()
+		os.RemoveAll(path)
+	}
+}
+
+// generateGCTestRandomChunk returns a chunk with random content and
+// address, suitable for exercising Put/Set without caring about its
+// actual data.
+func generateGCTestRandomChunk() storage.Chunk {
+	data := make([]byte, 4096)
+	rand.Read(data)
+	addr := make([]byte, 32)
+	rand.Read(addr)
+	return storage.NewChunk(addr, data)
+}
@@ -0,0 +1,110 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package localstore
+
+import (
+	"context"
+	"time"
+
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+var (
+	gcCollectedCounter = metrics.NewRegisteredCounter("storage.db.gc.collected", nil)
+	gcRunsCounter      = metrics.NewRegisteredCounter("storage.db.gc.runs", nil)
+)
+
+// GCRoundInfo describes the outcome of a single collectGarbage round.
+// It is sent to every subscriber registered with DB.SubscribeGC at the
+// end of every round, whether or not it removed any items. A round
+// whose batch write failed reports zero CollectedCount and a false
+// TriggeredNext.
+type GCRoundInfo struct {
+	// CollectedCount is the number of items removed in this round.
+	CollectedCount int64
+	// GCSize is the value of gcSize after this round's deletions were
+	// applied.
+	GCSize int64
+	// Target is the gcSize value this round aimed to reach, as
+	// returned by DB.GCTarget.
+	Target int64
+	// Duration is the wall time spent building and committing this
+	// round's batch.
+	Duration time.Duration
+	// TriggeredNext reports whether this round reached DB.GCBatchSize
+	// and requested another round, after the WithGCSchedule
+	// minInterval if one is set, to continue collecting.
+	TriggeredNext bool
+}
+
+// SubscribeGC returns a subscription that receives a GCRoundInfo
+// value after every collectGarbage round. It replaces the old
+// testHookCollectGarbage hook with a supported API that operators can
+// use to observe GC pressure, and that tests can use in place of the
+// hook to synchronize with GC runs.
+//
+// ch must be buffered with at least one slot, as required by
+// event.Feed, and the subscriber must keep draining it: reportGCRound
+// sends from the same goroutine that runs collectGarbage, so a
+// subscriber that falls behind or stops reading stalls every future GC
+// round on this database until it unsubscribes.
+func (db *DB) SubscribeGC(ch chan<- GCRoundInfo) event.Subscription {
+	return db.gcRoundFeed.Subscribe(ch)
+}
+
+// reportGCRound sends info to every subscriber registered with
+// SubscribeGC and updates the exported GC metrics. It is called by
+// collectGarbage at the end of every round, and blocks until every
+// subscriber has received info, so subscribers must keep draining
+// their channel promptly (see SubscribeGC).
+func (db *DB) reportGCRound(info GCRoundInfo) {
+	gcCollectedCounter.Inc(info.CollectedCount)
+	gcRunsCounter.Inc(1)
+	db.gcRoundFeed.Send(info)
+}
+
+// WaitForGC blocks until gcSize has been brought down to or below
+// db.GCTarget, or until ctx is done. It is intended for graceful
+// shutdown paths that want to let an in-progress GC round finish
+// draining the cache tier before the database is closed.
+//
+// It subscribes before doing its first GCSize check, so a round that
+// reaches target in the gap between the check and the subscribe call
+// is never missed.
+func (db *DB) WaitForGC(ctx context.Context) error {
+	ch := make(chan GCRoundInfo, 1)
+	sub := db.SubscribeGC(ch)
+	defer sub.Unsubscribe()
+
+	if db.GCSize() <= db.GCTarget() {
+		return nil
+	}
+
+	for {
+		select {
+		case info := <-ch:
+			if info.GCSize <= info.Target {
+				return nil
+			}
+		case err := <-sub.Err():
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package localstore
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// gcSchedule holds the parameters set by WithGCSchedule that pace
+// collectGarbage under sustained overflow.
+type gcSchedule struct {
+	// minInterval is slept between self-retriggered rounds.
+	minInterval time.Duration
+	// maxBatch is the largest gcBatchSize is allowed to grow back to.
+	maxBatch int64
+	// wallBudget is the wall time a single round's batch should not
+	// exceed before gcBatchSize is shrunk for the next round.
+	wallBudget time.Duration
+}
+
+// WithGCSchedule paces collectGarbage under sustained overflow instead
+// of letting it re-trigger itself immediately and monopolize the
+// leveldb write path. After each round, if the batch took longer than
+// wallBudget to build and commit, the effective batch size is halved
+// for the next round; if it finished comfortably under budget, the
+// batch size is doubled back up to maxBatch. minInterval is slept
+// between self-retriggered rounds so puts and gets are not starved
+// during a heavy eviction burst.
+func WithGCSchedule(minInterval time.Duration, maxBatch int64, wallBudget time.Duration) Option {
+	return func(db *DB) {
+		db.gcSchedule = gcSchedule{
+			minInterval: minInterval,
+			maxBatch:    maxBatch,
+			wallBudget:  wallBudget,
+		}
+		if maxBatch > 0 {
+			atomic.StoreInt64(&db.gcBatchSize, maxBatch)
+		}
+	}
+}
+
+// GCBatchSize returns the number of chunks collectGarbage will collect
+// in a single leveldb batch, as currently adjusted by
+// adjustGCBatchSize. It defaults to the package-level gcBatchSize
+// until WithGCSchedule or a prior round has set an effective value.
+func (db *DB) GCBatchSize() int64 {
+	if n := atomic.LoadInt64(&db.gcBatchSize); n > 0 {
+		return n
+	}
+	return gcBatchSize
+}
+
+// adjustGCBatchSize grows or shrinks db.gcBatchSize for the next round
+// based on how long the round that just finished took relative to
+// db.gcSchedule.wallBudget. It is a no-op if WithGCSchedule was never
+// called.
+func (db *DB) adjustGCBatchSize(duration time.Duration) {
+	wallBudget := db.gcSchedule.wallBudget
+	if wallBudget <= 0 {
+		return
+	}
+	current := db.GCBatchSize()
+	switch {
+	case duration > wallBudget:
+		next := current / 2
+		if next < 1 {
+			next = 1
+		}
+		atomic.StoreInt64(&db.gcBatchSize, next)
+	case duration < wallBudget/2:
+		max := db.gcSchedule.maxBatch
+		if max <= 0 {
+			max = gcBatchSize
+		}
+		next := current * 2
+		if next > max {
+			next = max
+		}
+		atomic.StoreInt64(&db.gcBatchSize, next)
+	}
+}
+
+// scheduleNextGCRound sleeps db.gcSchedule.minInterval, if set, then
+// re-triggers collectGarbage. Sleeping outside of batchMu lets puts
+// and gets make progress between self-retriggered rounds instead of
+// GC monopolizing the leveldb write path under sustained overflow.
+func (db *DB) scheduleNextGCRound() {
+	if db.gcSchedule.minInterval > 0 {
+		time.Sleep(db.gcSchedule.minInterval)
+	}
+	select {
+	case db.collectGarbageTrigger <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,270 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package localstore
+
+import (
+	"errors"
+	"sort"
+	"sync/atomic"
+
+	"github.com/ethereum/go-ethereum/swarm/shed"
+	"github.com/ethereum/go-ethereum/swarm/storage"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// ErrInvalidPinMode is returned by DB.Pin when called with an
+// unrecognised PinMode value.
+var ErrInvalidPinMode = errors.New("localstore: invalid pin mode")
+
+// PinMode enumerates the operations accepted by DB.Pin, mirroring the
+// ModeGet/ModePut/ModeSet pattern used elsewhere in localstore.
+type PinMode int
+
+// Pin mode values.
+const (
+	// ModePin moves chunks into the protected reserve tier.
+	ModePin PinMode = iota
+	// ModeUnpin returns chunks from the reserve tier to the cache tier.
+	ModeUnpin
+)
+
+var (
+	// reserveTargetRatio defines the target number of items left in
+	// the reserve index after a reserve overflow eviction, in the same
+	// way gcTargetRatio does for gcIndex.
+	reserveTargetRatio = 0.9
+)
+
+// ReserveScoreFunc returns a priority score for a chunk held in the
+// reserve tier. Lower scores are demoted to the cache tier first when
+// the reserve overflows its capacity. Implementations typically derive
+// the score from the proximity of the chunk address to the node's
+// overlay address, from postage-stamp priority, or a combination of
+// both.
+type ReserveScoreFunc func(item shed.IndexItem) (score int64)
+
+// WithReserveCapacity sets the number of chunks that can be held in
+// the protected reserve tier before the lowest scoring items are
+// demoted to the cache tier. A capacity of 0 disables the reserve and
+// all chunks remain subject to ordinary garbage collection.
+func WithReserveCapacity(capacity uint64) Option {
+	return func(db *DB) {
+		db.reserveCapacity = capacity
+	}
+}
+
+// WithReserveScoreFunc sets the function used to rank items held in
+// the reserve tier when it overflows its capacity. When it is not
+// set, reserve overflow falls back to access-time ordering, the same
+// order used for gcIndex.
+func WithReserveScoreFunc(f ReserveScoreFunc) Option {
+	return func(db *DB) {
+		db.reserveScoreFunc = f
+	}
+}
+
+// isPinned reports whether item is held in the reserve tier and must
+// therefore be skipped by collectGarbage.
+func (db *DB) isPinned(item shed.IndexItem) (bool, error) {
+	_, err := db.reserveIndex.Get(item)
+	if err != nil {
+		if err == leveldb.ErrNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// Pin performs a pin or unpin accessor operation, mode, on the chunks
+// with the given addresses.
+func (db *DB) Pin(mode PinMode, addrs ...storage.Address) error {
+	switch mode {
+	case ModePin:
+		return db.pin(addrs...)
+	case ModeUnpin:
+		return db.unpin(addrs...)
+	default:
+		return ErrInvalidPinMode
+	}
+}
+
+// pin adds the chunks with the given addresses to the reserve tier,
+// protecting them from garbage collection. If adding the items would
+// push the reserve beyond its capacity, the lowest scoring reserve
+// items are demoted to the cache tier to make room, rather than being
+// deleted outright.
+//
+// A reserveCapacity of 0 disables the reserve entirely, so pin is a
+// no-op in that case rather than pinning chunks with no overflow
+// valve to ever move them back.
+func (db *DB) pin(addrs ...storage.Address) error {
+	if db.reserveCapacity == 0 {
+		return nil
+	}
+
+	// batchMu is held across the has-check, the read of each item and
+	// the batch commit, not just the final write, so that two
+	// concurrent calls pinning the same address cannot both observe
+	// reserveIndex.Has as false and each commit their own pin,
+	// double-counting incGCSize/incReserveSize for what should be a
+	// single logical pin.
+	db.batchMu.Lock()
+	defer db.batchMu.Unlock()
+
+	batch := new(leveldb.Batch)
+	var pinnedCount int64
+	for _, addr := range addrs {
+		item := shed.IndexItem{Address: addr}
+		has, err := db.reserveIndex.Has(item)
+		if err != nil {
+			return err
+		}
+		if has {
+			continue
+		}
+		if db.useRetrievalCompositeIndex {
+			item, err = db.retrievalCompositeIndex.Get(item)
+		} else {
+			item, err = db.retrievalAccessIndex.Get(item)
+		}
+		if err != nil {
+			return err
+		}
+		db.reserveIndex.PutInBatch(batch, item)
+		db.gcIndex.DeleteInBatch(batch, item)
+		pinnedCount++
+	}
+	if err := db.shed.WriteBatch(batch); err != nil {
+		return err
+	}
+	db.incGCSize(-pinnedCount)
+	db.incReserveSize(pinnedCount)
+
+	return db.evictReserveOverflowLocked()
+}
+
+// unpin removes the chunks with the given addresses from the reserve
+// tier, returning them to the cache tier where they are subject to
+// ordinary garbage collection again.
+func (db *DB) unpin(addrs ...storage.Address) error {
+	// see pin for why batchMu spans the has-check through the commit
+	db.batchMu.Lock()
+	defer db.batchMu.Unlock()
+
+	batch := new(leveldb.Batch)
+	var unpinnedCount int64
+	for _, addr := range addrs {
+		item := shed.IndexItem{Address: addr}
+		has, err := db.reserveIndex.Has(item)
+		if err != nil {
+			return err
+		}
+		if !has {
+			continue
+		}
+		item, err = db.reserveIndex.Get(item)
+		if err != nil {
+			return err
+		}
+		db.reserveIndex.DeleteInBatch(batch, item)
+		db.gcIndex.PutInBatch(batch, item)
+		unpinnedCount++
+	}
+	if err := db.shed.WriteBatch(batch); err != nil {
+		return err
+	}
+	db.incReserveSize(-unpinnedCount)
+	db.incGCSize(unpinnedCount)
+	return nil
+}
+
+// evictReserveOverflow acquires batchMu and demotes the lowest scoring
+// items in the reserve tier to the cache tier until the reserve size
+// is back under its target. It is the entry point for callers other
+// than pin, which already holds batchMu when it needs the same work
+// done and calls evictReserveOverflowLocked directly instead.
+func (db *DB) evictReserveOverflow() error {
+	db.batchMu.Lock()
+	defer db.batchMu.Unlock()
+
+	return db.evictReserveOverflowLocked()
+}
+
+// evictReserveOverflowLocked is evictReserveOverflow's implementation.
+// Callers must hold db.batchMu. Reserve items are never deleted by
+// this method, only moved back to the cache tier where collectGarbage
+// may later remove them. Scoring, sorting and the batch commit all
+// happen under the same lock acquisition so that a concurrent
+// pin/unpin cannot change reserve membership between this method
+// choosing its candidates and committing their demotion.
+func (db *DB) evictReserveOverflowLocked() error {
+	if db.reserveCapacity == 0 {
+		return nil
+	}
+	reserveSize := atomic.LoadInt64(&db.reserveSize)
+	if uint64(reserveSize) <= db.reserveCapacity {
+		return nil
+	}
+	target := int64(float64(db.reserveCapacity) * reserveTargetRatio)
+
+	type scoredItem struct {
+		item  shed.IndexItem
+		score int64
+	}
+	var candidates []scoredItem
+	err := db.reserveIndex.IterateAll(func(item shed.IndexItem) (stop bool, err error) {
+		score := item.AccessTimestamp
+		if db.reserveScoreFunc != nil {
+			score = db.reserveScoreFunc(item)
+		}
+		candidates = append(candidates, scoredItem{item: item, score: score})
+		return false, nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].score < candidates[j].score
+	})
+
+	batch := new(leveldb.Batch)
+	var demotedCount int64
+	for _, c := range candidates {
+		if reserveSize-demotedCount <= target {
+			break
+		}
+		db.reserveIndex.DeleteInBatch(batch, c.item)
+		db.gcIndex.PutInBatch(batch, c.item)
+		demotedCount++
+	}
+	if demotedCount == 0 {
+		return nil
+	}
+	if err := db.shed.WriteBatch(batch); err != nil {
+		return err
+	}
+	db.incReserveSize(-demotedCount)
+	db.incGCSize(demotedCount)
+	return nil
+}
+
+// incReserveSize adjusts reserveSize by the provided count. If count
+// is negative, it decrements reserveSize.
+func (db *DB) incReserveSize(count int64) {
+	atomic.AddInt64(&db.reserveSize, count)
+}